@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditLogger emits one JSON line per endpoint invocation to a configurable
+// sink: stderr, a file path, or syslog.
+type AuditLogger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	syslog *syslog.Writer
+}
+
+func newAuditLogger(sink string) (*AuditLogger, error) {
+	switch sink {
+	case "", "stderr":
+		return &AuditLogger{w: os.Stderr}, nil
+	case "syslog":
+		w, err := syslog.New(syslog.LOG_INFO, "httpcmd")
+		if err != nil {
+			return nil, fmt.Errorf("audit log: %w", err)
+		}
+		return &AuditLogger{syslog: w}, nil
+	default:
+		f, err := os.OpenFile(sink, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("audit log: %w", err)
+		}
+		return &AuditLogger{w: f}, nil
+	}
+}
+
+// auditRecord is the shape of each emitted audit log line. Event is
+// "submitted" for async jobs accepted but not yet run, or "completed" once a
+// command (sync, cached, or async) has actually finished.
+type auditRecord struct {
+	Time       string   `json:"time"`
+	Event      string   `json:"event"`
+	Principal  string   `json:"principal,omitempty"`
+	RemoteAddr string   `json:"remote_addr"`
+	Endpoint   string   `json:"endpoint"`
+	Argv       []string `json:"argv"`
+	ExitCode   int      `json:"exit_code,omitempty"`
+	Duration   string   `json:"duration,omitempty"`
+	BytesOut   int      `json:"bytes_out,omitempty"`
+}
+
+func (a *AuditLogger) log(rec auditRecord) {
+	rec.Time = time.Now().UTC().Format(time.RFC3339Nano)
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.syslog != nil {
+		_, _ = a.syslog.Write(data)
+		return
+	}
+	_, _ = a.w.Write(data)
+}
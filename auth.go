@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// reloadAuthOnSIGHUP reloads every endpoint's bearer tokens or htpasswd file
+// each time the process receives SIGHUP, so credentials can be rotated
+// without a restart.
+func reloadAuthOnSIGHUP(cfg *Config) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+
+	for range ch {
+		for i := range cfg.Endpoints {
+			ep := &cfg.Endpoints[i]
+			if ep.Auth == nil {
+				continue
+			}
+			if err := ep.Auth.reload(); err != nil {
+				log.Printf("reload auth for %s: %v", ep.Path, err)
+				continue
+			}
+			log.Printf("reloaded auth for %s", ep.Path)
+		}
+	}
+}
+
+// AuthConfig guards an endpoint behind one of bearer token, HTTP basic,
+// mutual TLS, or HMAC-signed-body authentication.
+type AuthConfig struct {
+	Type       string `json:"type"`
+	TokensFile string `json:"tokens_file"`
+	Htpasswd   string `json:"htpasswd"`
+	ClientCA   string `json:"client_ca"`
+	SecretEnv  string `json:"secret_env"`
+	Header     string `json:"header"`
+
+	tokens   *tokenStore
+	htpasswd *htpasswdFile
+	caPool   *x509.CertPool
+}
+
+// load resolves the files an AuthConfig needs (tokens file, htpasswd file,
+// or client CA bundle) at config-load time so a bad path fails fast.
+func (a *AuthConfig) load() error {
+	switch a.Type {
+	case "bearer":
+		ts, err := newTokenStore(a.TokensFile)
+		if err != nil {
+			return err
+		}
+		a.tokens = ts
+	case "basic":
+		hf, err := newHtpasswdFile(a.Htpasswd)
+		if err != nil {
+			return err
+		}
+		a.htpasswd = hf
+	case "mtls":
+		pem, err := os.ReadFile(a.ClientCA)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("client_ca %q contains no usable certificates", a.ClientCA)
+		}
+		a.caPool = pool
+	case "hmac":
+		if a.SecretEnv == "" {
+			return errors.New("hmac auth requires secret_env")
+		}
+	default:
+		return fmt.Errorf("unsupported auth type %q", a.Type)
+	}
+	return nil
+}
+
+// reload re-reads the tokens or htpasswd file backing this AuthConfig, for
+// use by a SIGHUP handler. mTLS and HMAC have nothing to reload.
+func (a *AuthConfig) reload() error {
+	switch a.Type {
+	case "bearer":
+		return a.tokens.reload()
+	case "basic":
+		return a.htpasswd.reload()
+	}
+	return nil
+}
+
+var (
+	errAuthRequired = errors.New("authentication required")
+	errAuthInvalid  = errors.New("invalid credentials")
+)
+
+// authenticate checks r against ep's AuthConfig and returns the
+// authenticated principal, or an error if credentials are missing or wrong.
+func authenticate(auth *AuthConfig, r *http.Request, rawBody []byte) (string, error) {
+	switch auth.Type {
+	case "bearer":
+		token := bearerToken(r.Header.Get("Authorization"))
+		if token == "" {
+			return "", errAuthRequired
+		}
+		principal, ok := auth.tokens.lookup(token)
+		if !ok {
+			return "", errAuthInvalid
+		}
+		return principal, nil
+
+	case "basic":
+		user, pass, ok := r.BasicAuth()
+		if !ok {
+			return "", errAuthRequired
+		}
+		if !auth.htpasswd.verify(user, pass) {
+			return "", errAuthInvalid
+		}
+		return user, nil
+
+	case "mtls":
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			return "", errAuthRequired
+		}
+		cert := r.TLS.PeerCertificates[0]
+		opts := x509.VerifyOptions{
+			Roots:     auth.caPool,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		if _, err := cert.Verify(opts); err != nil {
+			return "", errAuthInvalid
+		}
+		return cert.Subject.CommonName, nil
+
+	case "hmac":
+		header := auth.Header
+		if header == "" {
+			header = "X-Signature"
+		}
+		sig := r.Header.Get(header)
+		if sig == "" {
+			return "", errAuthRequired
+		}
+		secret := os.Getenv(auth.SecretEnv)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(rawBody)
+		expected := hex.EncodeToString(mac.Sum(nil))
+		if !hmac.Equal([]byte(expected), []byte(strings.ToLower(strings.TrimSpace(sig)))) {
+			return "", errAuthInvalid
+		}
+		return "hmac", nil
+
+	default:
+		return "", fmt.Errorf("unsupported auth type %q", auth.Type)
+	}
+}
+
+func bearerToken(header string) string {
+	const prefix = "Bearer "
+	if !strings.HasPrefix(header, prefix) {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, prefix))
+}
+
+// tokenStore backs bearer auth: a flat file of "<token> [principal]" lines,
+// reloadable on SIGHUP.
+type tokenStore struct {
+	path string
+
+	mu     sync.RWMutex
+	tokens map[string]string
+}
+
+func newTokenStore(path string) (*tokenStore, error) {
+	ts := &tokenStore{path: path}
+	if err := ts.reload(); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+func (ts *tokenStore) reload() error {
+	data, err := os.ReadFile(ts.path)
+	if err != nil {
+		return err
+	}
+
+	tokens := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		principal := fields[0]
+		if len(fields) > 1 {
+			principal = fields[1]
+		}
+		tokens[fields[0]] = principal
+	}
+
+	ts.mu.Lock()
+	ts.tokens = tokens
+	ts.mu.Unlock()
+	return nil
+}
+
+func (ts *tokenStore) lookup(token string) (string, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	for candidate, principal := range ts.tokens {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+			return principal, true
+		}
+	}
+	return "", false
+}
+
+// htpasswdFile backs basic auth from a bcrypt-format htpasswd file
+// (entries created with `htpasswd -B`).
+type htpasswdFile struct {
+	path string
+
+	mu    sync.RWMutex
+	users map[string]string
+}
+
+func newHtpasswdFile(path string) (*htpasswdFile, error) {
+	hf := &htpasswdFile{path: path}
+	if err := hf.reload(); err != nil {
+		return nil, err
+	}
+	return hf, nil
+}
+
+func (hf *htpasswdFile) reload() error {
+	data, err := os.ReadFile(hf.path)
+	if err != nil {
+		return err
+	}
+
+	users := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		users[user] = hash
+	}
+
+	hf.mu.Lock()
+	hf.users = users
+	hf.mu.Unlock()
+	return nil
+}
+
+func (hf *htpasswdFile) verify(user, pass string) bool {
+	hf.mu.RLock()
+	hash, ok := hf.users[user]
+	hf.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheConfig enables response caching for an endpoint, keyed by a
+// configurable subset of the request.
+type CacheConfig struct {
+	TTLSeconds           int      `json:"ttl_seconds"`
+	KeyInclude           []string `json:"key_include"`
+	MaxEntries           int      `json:"max_entries"`
+	CacheErrors          bool     `json:"cache_errors"`
+	StaleWhileRevalidate bool     `json:"stale_while_revalidate"`
+}
+
+type cacheEntry struct {
+	Result   CommandResult
+	Status   int
+	StoredAt time.Time
+}
+
+// CacheStore is the interface response caching is implemented against, so
+// the in-memory LRU below can later be swapped for a disk- or Redis-backed
+// store without touching the endpoint handlers.
+type CacheStore interface {
+	Get(key string) (*cacheEntry, bool)
+	Set(key string, entry *cacheEntry, maxEntries int)
+}
+
+// lruCache is an in-memory, mutex-guarded LRU store. Entries are not
+// actively expired; staleness is judged by the caller comparing StoredAt
+// against the endpoint's configured TTL.
+type lruCache struct {
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	order      *list.List
+	refreshing map[string]bool
+}
+
+type lruElement struct {
+	key   string
+	entry *cacheEntry
+}
+
+func newLRUCache() *lruCache {
+	return &lruCache{
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		refreshing: make(map[string]bool),
+	}
+}
+
+func (c *lruCache) Get(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*lruElement).entry, true
+}
+
+func (c *lruCache) Set(key string, entry *cacheEntry, maxEntries int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*lruElement).entry = entry
+		c.order.MoveToFront(el)
+		return
+	}
+
+	el := c.order.PushFront(&lruElement{key: key, entry: entry})
+	c.entries[key] = el
+
+	if maxEntries > 0 {
+		for c.order.Len() > maxEntries {
+			oldest := c.order.Back()
+			if oldest == nil {
+				break
+			}
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*lruElement).key)
+		}
+	}
+}
+
+// tryStartRefresh reports whether the caller won the right to run a
+// background refresh for key, so concurrent stale hits don't all trigger one.
+func (c *lruCache) tryStartRefresh(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.refreshing[key] {
+		return false
+	}
+	c.refreshing[key] = true
+	return true
+}
+
+func (c *lruCache) finishRefresh(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.refreshing, key)
+}
+
+// serveCached runs ep's command behind a cache lookup keyed by the fields
+// named in ep.Cache.KeyInclude, writing an X-Cache and Age header alongside
+// the usual CommandResult response. rawBody is the request body the caller
+// already read and rewound onto r.Body; form-param handling upstream can
+// drain r.Body via r.ParseForm, so the cache key must be derived from this
+// buffered copy rather than reading r.Body again.
+func serveCached(w http.ResponseWriter, r *http.Request, defaultTimeout int, ep Endpoint, store *lruCache, rawBody []byte) CommandResult {
+	key := cacheKey(ep, r, rawBody)
+	ttl := time.Duration(ep.Cache.TTLSeconds) * time.Second
+
+	if entry, ok := store.Get(key); ok {
+		age := time.Since(entry.StoredAt)
+		if age <= ttl {
+			writeCached(w, entry, "HIT", age)
+			return entry.Result
+		}
+		if ep.Cache.StaleWhileRevalidate {
+			writeCached(w, entry, "STALE", age)
+			if store.tryStartRefresh(key) {
+				go func() {
+					defer store.finishRefresh(key)
+					refreshCache(defaultTimeout, ep, store, key)
+				}()
+			}
+			return entry.Result
+		}
+	}
+
+	result := runEndpoint(r.Context(), defaultTimeout, ep)
+	status := computeStatus(ep, result)
+	storeIfCacheable(store, key, ep, result, status)
+	w.Header().Set("X-Cache", "MISS")
+	writeJSON(w, status, result)
+	return result
+}
+
+func refreshCache(defaultTimeout int, ep Endpoint, store *lruCache, key string) {
+	result := runEndpoint(context.Background(), defaultTimeout, ep)
+	storeIfCacheable(store, key, ep, result, computeStatus(ep, result))
+}
+
+func storeIfCacheable(store *lruCache, key string, ep Endpoint, result CommandResult, status int) {
+	if result.ExitCode != 0 && !ep.Cache.CacheErrors {
+		return
+	}
+	store.Set(key, &cacheEntry{Result: result, Status: status, StoredAt: time.Now()}, ep.Cache.MaxEntries)
+}
+
+func writeCached(w http.ResponseWriter, entry *cacheEntry, state string, age time.Duration) {
+	w.Header().Set("X-Cache", state)
+	w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+	writeJSON(w, entry.Status, entry.Result)
+}
+
+// cacheKey hashes the endpoint path plus the request fields named in
+// ep.Cache.KeyInclude (defaulting to query string and body) into a single
+// lookup key.
+func cacheKey(ep Endpoint, r *http.Request, body []byte) string {
+	include := ep.Cache.KeyInclude
+	if len(include) == 0 {
+		include = []string{"query", "body_sha256"}
+	}
+
+	parts := []string{ep.Path}
+	for _, field := range include {
+		switch {
+		case field == "query":
+			parts = append(parts, "query="+r.URL.RawQuery)
+		case field == "body_sha256":
+			sum := sha256.Sum256(body)
+			parts = append(parts, "body="+hex.EncodeToString(sum[:]))
+		case strings.HasPrefix(field, "headers:"):
+			name := strings.TrimPrefix(field, "headers:")
+			parts = append(parts, "header:"+name+"="+r.Header.Get(name))
+		}
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
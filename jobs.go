@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JobStatus is the lifecycle state of an asynchronously submitted job.
+type JobStatus string
+
+const (
+	JobQueued  JobStatus = "queued"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+)
+
+// Job tracks an asynchronously executed endpoint invocation. Stdout and
+// stderr are spooled to disk rather than held in memory so a job's output
+// can grow far beyond what a single JSON response would comfortably hold.
+type Job struct {
+	ID        string
+	Path      string
+	Principal string
+	mu        sync.Mutex
+	status    JobStatus
+	created   time.Time
+	started   time.Time
+	finished  time.Time
+	exitCode  int
+	timedOut  bool
+	errMsg    string
+
+	stdoutPath string
+	stderrPath string
+}
+
+func (j *Job) snapshot() map[string]interface{} {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	out := map[string]interface{}{
+		"id":     j.ID,
+		"path":   j.Path,
+		"status": string(j.status),
+	}
+	if j.Principal != "" {
+		out["principal"] = j.Principal
+	}
+	if !j.started.IsZero() {
+		out["bytes_out"] = fileSize(j.stdoutPath) + fileSize(j.stderrPath)
+	}
+	switch j.status {
+	case JobRunning:
+		out["duration"] = time.Since(j.started).String()
+	case JobDone:
+		out["duration"] = j.finished.Sub(j.started).String()
+		out["exit_code"] = j.exitCode
+		out["timed_out"] = j.timedOut
+		if j.errMsg != "" {
+			out["error"] = j.errMsg
+		}
+	}
+	return out
+}
+
+func fileSize(path string) int64 {
+	if path == "" {
+		return 0
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// JobManager owns the in-memory job table, a bounded worker pool, and the
+// spool directory jobs write their output to. endpoints and rateLimiters let
+// handle() apply the same auth and rate-limit policy to job retrieval that
+// the submitting endpoint applies to submission.
+type JobManager struct {
+	mu           sync.Mutex
+	jobs         map[string]*Job
+	sem          chan struct{}
+	spoolDir     string
+	ttl          time.Duration
+	audit        *AuditLogger
+	endpoints    map[string]Endpoint
+	rateLimiters map[string]*rateLimiter
+}
+
+func newJobManager(maxConcurrent int, spoolDir string, ttl time.Duration, audit *AuditLogger, endpoints map[string]Endpoint, rateLimiters map[string]*rateLimiter) *JobManager {
+	return &JobManager{
+		jobs:         make(map[string]*Job),
+		sem:          make(chan struct{}, maxConcurrent),
+		spoolDir:     spoolDir,
+		ttl:          ttl,
+		audit:        audit,
+		endpoints:    endpoints,
+		rateLimiters: rateLimiters,
+	}
+}
+
+func (jm *JobManager) submit(parent context.Context, defaultTimeout int, ep Endpoint, principal string) *Job {
+	job := &Job{
+		ID:        newJobID(),
+		Path:      ep.Path,
+		Principal: principal,
+		status:    JobQueued,
+		created:   time.Now(),
+	}
+
+	jm.mu.Lock()
+	jm.jobs[job.ID] = job
+	jm.mu.Unlock()
+
+	go jm.run(parent, defaultTimeout, ep, job, principal)
+
+	return job
+}
+
+func (jm *JobManager) run(parent context.Context, defaultTimeout int, ep Endpoint, job *Job, principal string) {
+	jm.sem <- struct{}{}
+	defer func() { <-jm.sem }()
+
+	stdoutFile, err := os.CreateTemp(jm.spoolDir, "httpcmd-job-"+job.ID+"-stdout-*")
+	if err != nil {
+		jm.fail(job, ep, principal, err)
+		return
+	}
+	defer stdoutFile.Close()
+
+	// Record stdoutPath as soon as the file exists, so a subsequent failure
+	// creating the stderr file still leaves jm.evict (via jm.fail) able to
+	// find and remove it; otherwise it would leak on disk forever.
+	job.mu.Lock()
+	job.stdoutPath = stdoutFile.Name()
+	job.mu.Unlock()
+
+	stderrFile, err := os.CreateTemp(jm.spoolDir, "httpcmd-job-"+job.ID+"-stderr-*")
+	if err != nil {
+		jm.fail(job, ep, principal, err)
+		return
+	}
+	defer stderrFile.Close()
+
+	job.mu.Lock()
+	job.status = JobRunning
+	job.started = time.Now()
+	job.stderrPath = stderrFile.Name()
+	job.mu.Unlock()
+
+	cmd, ctx, cancel := newCommandContext(parent, defaultTimeout, ep)
+	defer cancel()
+
+	var exitCode int
+	var timedOut bool
+	var errMsg string
+
+	if ep.ParseWorkflowCommands {
+		// Workflow-command parsing (and the ::add-mask:: scrubbing that
+		// comes with it) needs the full output in hand before anything is
+		// written out, so this path buffers in memory instead of streaming
+		// straight to the spool files.
+		var stdoutBuf, stderrBuf bytes.Buffer
+		cmd.Stdout = &stdoutBuf
+		cmd.Stderr = &stderrBuf
+
+		runErr := cmd.Run()
+		timedOut = errors.Is(ctx.Err(), context.DeadlineExceeded)
+		result := finalizeResult(ep, stdoutBuf.String(), stderrBuf.String(), runErr, timedOut, job.started)
+		exitCode = result.ExitCode
+		errMsg = result.Error
+
+		if _, err := stdoutFile.WriteString(result.Stdout); err != nil {
+			jm.fail(job, ep, principal, err)
+			return
+		}
+		if _, err := stderrFile.WriteString(result.Stderr); err != nil {
+			jm.fail(job, ep, principal, err)
+			return
+		}
+	} else {
+		cmd.Stdout = stdoutFile
+		cmd.Stderr = stderrFile
+
+		runErr := cmd.Run()
+		exitCode, timedOut = exitStatus(ctx, runErr)
+		if runErr != nil && exitCode == -1 {
+			if timedOut {
+				errMsg = "command timed out"
+			} else {
+				errMsg = runErr.Error()
+			}
+		}
+	}
+
+	job.mu.Lock()
+	job.status = JobDone
+	job.finished = time.Now()
+	job.exitCode = exitCode
+	job.timedOut = timedOut
+	job.errMsg = errMsg
+	job.mu.Unlock()
+
+	jm.audit.log(auditRecord{
+		Event:     "completed",
+		Principal: principal,
+		Endpoint:  ep.Path,
+		Argv:      ep.Command,
+		ExitCode:  exitCode,
+		Duration:  job.finished.Sub(job.started).String(),
+		BytesOut:  int(fileSize(job.stdoutPath) + fileSize(job.stderrPath)),
+	})
+
+	time.AfterFunc(jm.ttl, func() { jm.evict(job.ID) })
+}
+
+func (jm *JobManager) fail(job *Job, ep Endpoint, principal string, err error) {
+	job.mu.Lock()
+	job.status = JobDone
+	job.finished = time.Now()
+	job.exitCode = -1
+	job.errMsg = err.Error()
+	job.mu.Unlock()
+
+	jm.audit.log(auditRecord{
+		Event:     "completed",
+		Principal: principal,
+		Endpoint:  ep.Path,
+		Argv:      ep.Command,
+		ExitCode:  -1,
+	})
+
+	time.AfterFunc(jm.ttl, func() { jm.evict(job.ID) })
+}
+
+func (jm *JobManager) evict(id string) {
+	jm.mu.Lock()
+	job, ok := jm.jobs[id]
+	delete(jm.jobs, id)
+	jm.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	if job.stdoutPath != "" {
+		_ = os.Remove(job.stdoutPath)
+	}
+	if job.stderrPath != "" {
+		_ = os.Remove(job.stderrPath)
+	}
+}
+
+func (jm *JobManager) get(id string) (*Job, bool) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	job, ok := jm.jobs[id]
+	return job, ok
+}
+
+// handle serves GET /jobs/{id} and GET /jobs/{id}/result.
+func (jm *JobManager) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	id, wantsResult := strings.CutSuffix(rest, "/result")
+	if id == "" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "job not found"})
+		return
+	}
+
+	job, ok := jm.get(id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "job not found"})
+		return
+	}
+
+	principal := ""
+	if ep, ok := jm.endpoints[job.Path]; ok {
+		if ep.Auth != nil {
+			p, err := authenticate(ep.Auth, r, nil)
+			if err != nil {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+				return
+			}
+			principal = p
+		}
+		if ep.Rate != nil {
+			if !jm.rateLimiters[ep.Path].allow(clientKey(principal, r.RemoteAddr)) {
+				writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "rate limit exceeded"})
+				return
+			}
+		}
+	}
+	if job.Principal != "" && principal != job.Principal {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "not the submitting principal"})
+		return
+	}
+
+	if wantsResult {
+		jm.writeResult(w, job)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job.snapshot())
+}
+
+// writeResult streams the job's final record as multipart/mixed: a JSON
+// metadata part followed by raw stdout and stderr parts, so large output
+// never has to be embedded inside a single JSON string.
+func (jm *JobManager) writeResult(w http.ResponseWriter, job *Job) {
+	job.mu.Lock()
+	status := job.status
+	stdoutPath := job.stdoutPath
+	stderrPath := job.stderrPath
+	job.mu.Unlock()
+
+	if status != JobDone {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "job not finished", "status": string(status)})
+		return
+	}
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusOK)
+	defer mw.Close()
+
+	metaPart, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":        {"application/json"},
+		"Content-Disposition": {`inline; name="metadata"`},
+	})
+	if err != nil {
+		return
+	}
+	if err := json.NewEncoder(metaPart).Encode(job.snapshot()); err != nil {
+		return
+	}
+
+	if err := copyPart(mw, "stdout", stdoutPath); err != nil {
+		return
+	}
+	_ = copyPart(mw, "stderr", stderrPath)
+}
+
+func copyPart(mw *multipart.Writer, name, path string) error {
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":        {"application/octet-stream"},
+		"Content-Disposition": {fmt.Sprintf(`inline; name=%q`, name)},
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(part, f)
+	return err
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
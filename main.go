@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -24,14 +25,41 @@ type Config struct {
 	Addr                  string     `json:"addr"`
 	DefaultTimeoutSeconds int        `json:"default_timeout_seconds"`
 	Endpoints             []Endpoint `json:"endpoints"`
+	MaxConcurrentJobs     int        `json:"max_concurrent_jobs"`
+	SpoolDir              string     `json:"spool_dir"`
+	JobTTLSeconds         int        `json:"job_ttl_seconds"`
+	AuditLog              string     `json:"audit_log"`
+
+	// TLSCert and TLSKey, if both set, make the server listen with TLS. Any
+	// endpoint using "mtls" auth requires them, since that auth type checks
+	// the client certificate on the connection, and there is no connection
+	// to check one on without a TLS listener.
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
 }
 
 type Endpoint struct {
-	Path           string   `json:"path"`
-	Command        []string `json:"command"`
-	WorkDir        string   `json:"work_dir"`
-	TimeoutSeconds *int     `json:"timeout_seconds"`
-	PTY            bool     `json:"pty"`
+	Path           string            `json:"path"`
+	Command        []string          `json:"command"`
+	WorkDir        string            `json:"work_dir"`
+	TimeoutSeconds *int              `json:"timeout_seconds"`
+	PTY            bool              `json:"pty"`
+	Mode           string            `json:"mode"`
+	Async          bool              `json:"async"`
+	Cache          *CacheConfig      `json:"cache"`
+	Params         []ParamSpec       `json:"params"`
+	Env            map[string]string `json:"env"`
+	StdinFrom      string            `json:"stdin_from"`
+
+	ParseWorkflowCommands bool  `json:"parse_workflow_commands"`
+	PromoteErrorStatus    *bool `json:"promote_error_status"`
+
+	Auth *AuthConfig `json:"auth"`
+	Rate *RateConfig `json:"rate"`
+
+	// stdinBody is populated at request time when StdinFrom is "body"; it
+	// is not part of the JSON config.
+	stdinBody []byte
 }
 
 type CommandResult struct {
@@ -42,6 +70,10 @@ type CommandResult struct {
 	Duration string `json:"duration"`
 	TimedOut bool   `json:"timed_out"`
 	Error    string `json:"error,omitempty"`
+
+	Annotations []Annotation `json:"annotations,omitempty"`
+	Groups      []Group      `json:"groups,omitempty"`
+	Summary     string       `json:"summary,omitempty"`
 }
 
 func main() {
@@ -53,24 +85,149 @@ func main() {
 		log.Fatalf("config error: %v", err)
 	}
 
+	auditLogger, err := newAuditLogger(cfg.AuditLog)
+	if err != nil {
+		log.Fatalf("audit log error: %v", err)
+	}
+
+	cacheStore := newLRUCache()
+
+	rateLimiters := make(map[string]*rateLimiter, len(cfg.Endpoints))
+	endpointsByPath := make(map[string]Endpoint, len(cfg.Endpoints))
+	for _, ep := range cfg.Endpoints {
+		if ep.Rate != nil {
+			rateLimiters[ep.Path] = newRateLimiter(ep.Rate.RPS, ep.Rate.Burst)
+		}
+		endpointsByPath[ep.Path] = ep
+	}
+
+	jobs := newJobManager(cfg.MaxConcurrentJobs, cfg.SpoolDir, time.Duration(cfg.JobTTLSeconds)*time.Second, auditLogger, endpointsByPath, rateLimiters)
+
+	go reloadAuthOnSIGHUP(cfg)
+
 	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs/", jobs.handle)
+
 	for _, ep := range cfg.Endpoints {
 		ep := ep
+		if ep.Mode == "websocket" {
+			mux.HandleFunc(ep.Path, func(w http.ResponseWriter, r *http.Request) {
+				reqEp := ep
+				var rawBody []byte
+				if r.Body != nil {
+					rawBody, _ = io.ReadAll(r.Body)
+					r.Body = io.NopCloser(bytes.NewReader(rawBody))
+				}
+
+				principal := ""
+				if reqEp.Auth != nil {
+					p, err := authenticate(reqEp.Auth, r, rawBody)
+					if err != nil {
+						writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+						return
+					}
+					principal = p
+				}
+				if reqEp.Rate != nil {
+					if !rateLimiters[reqEp.Path].allow(clientKey(principal, r.RemoteAddr)) {
+						writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "rate limit exceeded"})
+						return
+					}
+				}
+
+				start := time.Now()
+				exitCode, bytesOut := serveWebSocket(w, r, cfg.DefaultTimeoutSeconds, reqEp)
+				auditLogger.log(auditRecord{
+					Event:      "completed",
+					Principal:  principal,
+					RemoteAddr: r.RemoteAddr,
+					Endpoint:   reqEp.Path,
+					Argv:       reqEp.Command,
+					ExitCode:   exitCode,
+					Duration:   time.Since(start).String(),
+					BytesOut:   bytesOut,
+				})
+			})
+			continue
+		}
 		mux.HandleFunc(ep.Path, func(w http.ResponseWriter, r *http.Request) {
 			if r.Method != http.MethodGet && r.Method != http.MethodPost {
 				w.Header().Set("Allow", "GET, POST")
 				writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
 				return
 			}
-			result := runEndpoint(r.Context(), cfg.DefaultTimeoutSeconds, ep)
-			status := http.StatusOK
-			if result.Error != "" {
-				status = http.StatusInternalServerError
+
+			reqEp := ep
+			var rawBody []byte
+			if r.Body != nil {
+				rawBody, _ = io.ReadAll(r.Body)
+				r.Body = io.NopCloser(bytes.NewReader(rawBody))
+			}
+
+			principal := ""
+			if reqEp.Auth != nil {
+				p, err := authenticate(reqEp.Auth, r, rawBody)
+				if err != nil {
+					writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+					return
+				}
+				principal = p
+			}
+			if reqEp.Rate != nil {
+				if !rateLimiters[reqEp.Path].allow(clientKey(principal, r.RemoteAddr)) {
+					writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "rate limit exceeded"})
+					return
+				}
+			}
+
+			if len(reqEp.Params) > 0 || reqEp.StdinFrom != "" {
+				rendered, paramErrs, err := renderEndpoint(reqEp, r, rawBody)
+				if err != nil {
+					writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+					return
+				}
+				if len(paramErrs) > 0 {
+					writeJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": paramErrs})
+					return
+				}
+				reqEp = rendered
+			}
+
+			if reqEp.Async || r.URL.Query().Get("async") == "1" {
+				// The job outlives this request, so it must not inherit a
+				// context that gets cancelled when the handler returns.
+				job := jobs.submit(context.Background(), cfg.DefaultTimeoutSeconds, reqEp, principal)
+				auditLogger.log(auditRecord{
+					Event:      "submitted",
+					Principal:  principal,
+					RemoteAddr: r.RemoteAddr,
+					Endpoint:   reqEp.Path,
+					Argv:       reqEp.Command,
+				})
+				w.Header().Set("Location", "/jobs/"+job.ID)
+				writeJSON(w, http.StatusAccepted, map[string]string{"id": job.ID, "location": "/jobs/" + job.ID})
+				return
 			}
-			if result.TimedOut {
-				status = http.StatusGatewayTimeout
+
+			start := time.Now()
+			var result CommandResult
+			if reqEp.Cache != nil {
+				result = serveCached(w, r, cfg.DefaultTimeoutSeconds, reqEp, cacheStore, rawBody)
+			} else {
+				result = runEndpoint(r.Context(), cfg.DefaultTimeoutSeconds, reqEp)
+				writeJSON(w, computeStatus(reqEp, result), result)
 			}
-			writeJSON(w, status, result)
+
+			auditLogger.log(auditRecord{
+				Event:      "completed",
+				Principal:  principal,
+				RemoteAddr: r.RemoteAddr,
+				Endpoint:   reqEp.Path,
+				Argv:       reqEp.Command,
+				ExitCode:   result.ExitCode,
+				Duration:   time.Since(start).String(),
+				BytesOut:   len(result.Stdout) + len(result.Stderr),
+			})
 		})
 	}
 
@@ -79,6 +236,22 @@ func main() {
 		addr = ":8080"
 	}
 
+	if cfg.TLSCert != "" {
+		// RequestClientCert (not RequireAndVerifyClientCert) so endpoints
+		// without mtls auth aren't forced to present a client cert on the
+		// same listener; authenticate() does the actual per-endpoint chain
+		// verification against ep.Auth.caPool.
+		srv := &http.Server{
+			Addr:    addr,
+			Handler: mux,
+			TLSConfig: &tls.Config{
+				ClientAuth: tls.RequestClientCert,
+			},
+		}
+		log.Printf("listening on %s (tls)", addr)
+		log.Fatal(srv.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey))
+	}
+
 	log.Printf("listening on %s", addr)
 	log.Fatal(http.ListenAndServe(addr, mux))
 }
@@ -98,6 +271,7 @@ func loadConfig(path string) (*Config, error) {
 		return nil, errors.New("config must include at least one endpoint")
 	}
 
+	usesMTLS := false
 	seen := make(map[string]struct{}, len(cfg.Endpoints))
 	for i := range cfg.Endpoints {
 		ep := &cfg.Endpoints[i]
@@ -110,20 +284,70 @@ func loadConfig(path string) (*Config, error) {
 		if ep.WorkDir == "" {
 			return nil, fmt.Errorf("endpoint %q must include a work_dir", ep.Path)
 		}
-		abs, err := filepath.Abs(ep.WorkDir)
-		if err != nil {
-			return nil, fmt.Errorf("endpoint %q work_dir error: %w", ep.Path, err)
-		}
-		if info, err := os.Stat(abs); err != nil {
-			return nil, fmt.Errorf("endpoint %q work_dir error: %w", ep.Path, err)
-		} else if !info.IsDir() {
-			return nil, fmt.Errorf("endpoint %q work_dir is not a directory: %s", ep.Path, abs)
+		if strings.Contains(ep.WorkDir, "{{") {
+			// Templated work_dir is resolved per-request from validated
+			// params; it can't be checked until then.
+		} else {
+			abs, err := filepath.Abs(ep.WorkDir)
+			if err != nil {
+				return nil, fmt.Errorf("endpoint %q work_dir error: %w", ep.Path, err)
+			}
+			if info, err := os.Stat(abs); err != nil {
+				return nil, fmt.Errorf("endpoint %q work_dir error: %w", ep.Path, err)
+			} else if !info.IsDir() {
+				return nil, fmt.Errorf("endpoint %q work_dir is not a directory: %s", ep.Path, abs)
+			}
+			ep.WorkDir = abs
 		}
-		ep.WorkDir = abs
 		if _, ok := seen[ep.Path]; ok {
 			return nil, fmt.Errorf("duplicate endpoint path: %q", ep.Path)
 		}
 		seen[ep.Path] = struct{}{}
+		if err := validateParams(ep.Params); err != nil {
+			return nil, fmt.Errorf("endpoint %q: %w", ep.Path, err)
+		}
+		if ep.PTY && ep.StdinFrom == "body" {
+			// pty.Start only wires the PTY master into cmd.Stdin when it's
+			// still nil, so pre-setting it from the request body would
+			// silently replace the PTY slave with a plain reader and break
+			// the terminal (no line discipline, no control characters).
+			return nil, fmt.Errorf("endpoint %q: pty and stdin_from \"body\" cannot be combined", ep.Path)
+		}
+		if ep.Auth != nil {
+			if err := ep.Auth.load(); err != nil {
+				return nil, fmt.Errorf("endpoint %q auth error: %w", ep.Path, err)
+			}
+			if ep.Auth.Type == "mtls" {
+				usesMTLS = true
+			}
+		}
+		if ep.Rate != nil && ep.Rate.RPS <= 0 {
+			return nil, fmt.Errorf("endpoint %q: rate.rps must be > 0", ep.Path)
+		}
+		if ep.Cache != nil && ep.Cache.MaxEntries <= 0 {
+			// An unset limit would let the LRU grow without bound; cap it
+			// to something generous but finite by default.
+			ep.Cache.MaxEntries = 1000
+		}
+	}
+
+	if cfg.MaxConcurrentJobs <= 0 {
+		cfg.MaxConcurrentJobs = 4
+	}
+	if cfg.SpoolDir == "" {
+		cfg.SpoolDir = os.TempDir()
+	}
+	if err := os.MkdirAll(cfg.SpoolDir, 0o755); err != nil {
+		return nil, fmt.Errorf("spool_dir error: %w", err)
+	}
+	if cfg.JobTTLSeconds <= 0 {
+		cfg.JobTTLSeconds = 3600
+	}
+	if cfg.AuditLog == "" {
+		cfg.AuditLog = "stderr"
+	}
+	if usesMTLS && (cfg.TLSCert == "" || cfg.TLSKey == "") {
+		return nil, errors.New("tls_cert and tls_key are required when any endpoint uses mtls auth")
 	}
 
 	return &cfg, nil
@@ -132,29 +356,47 @@ func loadConfig(path string) (*Config, error) {
 func runEndpoint(parent context.Context, defaultTimeout int, ep Endpoint) CommandResult {
 	start := time.Now()
 
+	cmd, ctx, cancel := newCommandContext(parent, defaultTimeout, ep)
+	defer cancel()
+
+	if ep.PTY {
+		return runWithPTY(cmd, ep, start, ctx)
+	}
+
+	return runWithPipes(cmd, ep, start, ctx)
+}
+
+// newCommandContext builds the exec.Cmd and timeout context shared by every
+// endpoint execution path (buffered, PTY, and streaming).
+func newCommandContext(parent context.Context, defaultTimeout int, ep Endpoint) (*exec.Cmd, context.Context, context.CancelFunc) {
 	timeout := defaultTimeout
 	if ep.TimeoutSeconds != nil {
 		timeout = *ep.TimeoutSeconds
 	}
 
 	ctx := parent
-	cancel := func() {}
+	cancel := context.CancelFunc(func() {})
 	if timeout > 0 {
 		ctx, cancel = context.WithTimeout(parent, time.Duration(timeout)*time.Second)
 	}
-	defer cancel()
 
 	cmd := exec.CommandContext(ctx, ep.Command[0], ep.Command[1:]...)
 	cmd.Dir = ep.WorkDir
 
-	if ep.PTY {
-		return runWithPTY(cmd, ep.Path, start, ctx)
+	if len(ep.Env) > 0 {
+		cmd.Env = os.Environ()
+		for k, v := range ep.Env {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
+	}
+	if ep.stdinBody != nil {
+		cmd.Stdin = bytes.NewReader(ep.stdinBody)
 	}
 
-	return runWithPipes(cmd, ep.Path, start, ctx)
+	return cmd, ctx, cancel
 }
 
-func runWithPipes(cmd *exec.Cmd, path string, start time.Time, ctx context.Context) CommandResult {
+func runWithPipes(cmd *exec.Cmd, ep Endpoint, start time.Time, ctx context.Context) CommandResult {
 	var stdoutBuf bytes.Buffer
 	var stderrBuf bytes.Buffer
 	cmd.Stdout = &stdoutBuf
@@ -163,14 +405,14 @@ func runWithPipes(cmd *exec.Cmd, path string, start time.Time, ctx context.Conte
 	err := cmd.Run()
 	timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
 
-	return finalizeResult(path, stdoutBuf.String(), stderrBuf.String(), err, timedOut, start)
+	return finalizeResult(ep, stdoutBuf.String(), stderrBuf.String(), err, timedOut, start)
 }
 
-func runWithPTY(cmd *exec.Cmd, path string, start time.Time, ctx context.Context) CommandResult {
+func runWithPTY(cmd *exec.Cmd, ep Endpoint, start time.Time, ctx context.Context) CommandResult {
 	ptyFile, err := pty.Start(cmd)
 	if err != nil {
 		return CommandResult{
-			Path:     path,
+			Path:     ep.Path,
 			ExitCode: -1,
 			Duration: time.Since(start).String(),
 			TimedOut: errors.Is(ctx.Err(), context.DeadlineExceeded),
@@ -186,10 +428,10 @@ func runWithPTY(cmd *exec.Cmd, path string, start time.Time, ctx context.Context
 	}
 
 	timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
-	return finalizeResult(path, string(output), "", waitErr, timedOut, start)
+	return finalizeResult(ep, string(output), "", waitErr, timedOut, start)
 }
 
-func finalizeResult(path, stdout, stderr string, err error, timedOut bool, start time.Time) CommandResult {
+func finalizeResult(ep Endpoint, stdout, stderr string, err error, timedOut bool, start time.Time) CommandResult {
 	exitCode := 0
 	errorMessage := ""
 	if err != nil {
@@ -205,15 +447,24 @@ func finalizeResult(path, stdout, stderr string, err error, timedOut bool, start
 		}
 	}
 
-	return CommandResult{
-		Path:     path,
+	stdout = strings.TrimSpace(stdout)
+	stderr = strings.TrimSpace(stderr)
+
+	result := CommandResult{
+		Path:     ep.Path,
 		ExitCode: exitCode,
-		Stdout:   strings.TrimSpace(stdout),
-		Stderr:   strings.TrimSpace(stderr),
+		Stdout:   stdout,
+		Stderr:   stderr,
 		Duration: time.Since(start).String(),
 		TimedOut: timedOut,
 		Error:    errorMessage,
 	}
+
+	if ep.ParseWorkflowCommands {
+		applyWorkflowCommands(&result)
+	}
+
+	return result
 }
 
 func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// ParamSpec declares one allowed input an endpoint may be parameterized by.
+// Only declared params are ever substituted into Command, WorkDir, or Env,
+// and each value must match Pattern before it is used.
+type ParamSpec struct {
+	Name     string `json:"name"`
+	In       string `json:"in"`
+	Pattern  string `json:"pattern"`
+	Required bool   `json:"required"`
+}
+
+var validParamLocations = map[string]bool{
+	"query":  true,
+	"form":   true,
+	"body":   true,
+	"json":   true,
+	"header": true,
+	"path":   true,
+}
+
+func validateParams(params []ParamSpec) error {
+	for _, p := range params {
+		if p.Name == "" {
+			return fmt.Errorf("param missing name")
+		}
+		if !validParamLocations[p.In] {
+			return fmt.Errorf("param %q has unsupported \"in\" value %q", p.Name, p.In)
+		}
+		if p.Pattern != "" {
+			if _, err := regexp.Compile(p.Pattern); err != nil {
+				return fmt.Errorf("param %q has invalid pattern: %w", p.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+type paramError struct {
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+// templateContext is the data a Command/WorkDir/Env template is executed
+// against, e.g. {{.Query.foo}} or {{.Body.bar}}.
+type templateContext struct {
+	Query  map[string]string
+	Form   map[string]string
+	Body   map[string]string
+	Header map[string]string
+	Path   string
+}
+
+// resolveParams extracts, validates, and collects every declared param from
+// the request. It returns the context to template against, or the list of
+// validation failures if any param is missing or fails its pattern.
+func resolveParams(ep Endpoint, r *http.Request, rawBody []byte) (templateContext, []paramError) {
+	ctx := templateContext{
+		Query:  map[string]string{},
+		Form:   map[string]string{},
+		Body:   map[string]string{},
+		Header: map[string]string{},
+	}
+
+	var bodyFields map[string]interface{}
+	var errs []paramError
+
+	for _, p := range ep.Params {
+		raw, err := extractParam(ep, r, rawBody, &bodyFields, p)
+		if err != nil {
+			errs = append(errs, paramError{Name: p.Name, Message: err.Error()})
+			continue
+		}
+
+		if raw == "" {
+			if p.Required {
+				errs = append(errs, paramError{Name: p.Name, Message: "missing required parameter"})
+			}
+			continue
+		}
+
+		if p.Pattern != "" {
+			matched, err := regexp.MatchString(p.Pattern, raw)
+			if err != nil {
+				errs = append(errs, paramError{Name: p.Name, Message: fmt.Sprintf("invalid pattern: %v", err)})
+				continue
+			}
+			if !matched {
+				errs = append(errs, paramError{Name: p.Name, Message: "does not match required pattern"})
+				continue
+			}
+		}
+
+		switch p.In {
+		case "query":
+			ctx.Query[p.Name] = raw
+		case "form":
+			ctx.Form[p.Name] = raw
+		case "body", "json":
+			ctx.Body[p.Name] = raw
+		case "header":
+			ctx.Header[p.Name] = raw
+		case "path":
+			ctx.Path = raw
+		}
+	}
+
+	return ctx, errs
+}
+
+func extractParam(ep Endpoint, r *http.Request, rawBody []byte, bodyFields *map[string]interface{}, p ParamSpec) (string, error) {
+	switch p.In {
+	case "query":
+		return r.URL.Query().Get(p.Name), nil
+	case "form":
+		if err := r.ParseForm(); err != nil {
+			return "", fmt.Errorf("invalid form body: %w", err)
+		}
+		return r.PostFormValue(p.Name), nil
+	case "body", "json":
+		if *bodyFields == nil {
+			fields := map[string]interface{}{}
+			if len(rawBody) > 0 {
+				if err := json.Unmarshal(rawBody, &fields); err != nil {
+					return "", fmt.Errorf("invalid JSON body: %w", err)
+				}
+			}
+			*bodyFields = fields
+		}
+		v, ok := (*bodyFields)[p.Name]
+		if !ok || v == nil {
+			return "", nil
+		}
+		return fmt.Sprintf("%v", v), nil
+	case "header":
+		return r.Header.Get(p.Name), nil
+	case "path":
+		return strings.TrimPrefix(r.URL.Path, ep.Path), nil
+	default:
+		return "", fmt.Errorf("unsupported param location %q", p.In)
+	}
+}
+
+func renderTemplate(text string, ctx templateContext) (string, error) {
+	tmpl, err := template.New("param").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, ctx); err != nil {
+		return "", fmt.Errorf("template error: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// renderEndpoint validates ep's declared params against the request and, on
+// success, returns a copy of ep with Command, WorkDir, and Env templated
+// against the validated values. Substituted values are always passed as
+// whole argv elements, never concatenated into a shell string.
+func renderEndpoint(ep Endpoint, r *http.Request, rawBody []byte) (Endpoint, []paramError, error) {
+	ctx, errs := resolveParams(ep, r, rawBody)
+	if len(errs) > 0 {
+		return ep, errs, nil
+	}
+
+	rendered := ep
+
+	rendered.Command = make([]string, len(ep.Command))
+	for i, arg := range ep.Command {
+		out, err := renderTemplate(arg, ctx)
+		if err != nil {
+			return ep, nil, err
+		}
+		rendered.Command[i] = out
+	}
+
+	if strings.Contains(ep.WorkDir, "{{") {
+		out, err := renderTemplate(ep.WorkDir, ctx)
+		if err != nil {
+			return ep, nil, err
+		}
+		rendered.WorkDir = out
+	}
+
+	if len(ep.Env) > 0 {
+		rendered.Env = make(map[string]string, len(ep.Env))
+		for k, v := range ep.Env {
+			out, err := renderTemplate(v, ctx)
+			if err != nil {
+				return ep, nil, err
+			}
+			rendered.Env[k] = out
+		}
+	}
+
+	if ep.StdinFrom == "body" {
+		rendered.stdinBody = rawBody
+	}
+
+	return rendered, nil, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// bucketIdleTTL bounds how long an idle rate-limit bucket is kept around.
+// Without this, a rate limiter keyed by remote address or principal would
+// grow forever as distinct clients come and go.
+const bucketIdleTTL = 1 * time.Hour
+
+// RateConfig configures a token-bucket rate limiter for an endpoint, keyed
+// per authenticated principal (or remote address when unauthenticated).
+type RateConfig struct {
+	RPS   float64 `json:"rps"`
+	Burst int     `json:"burst"`
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter holds one token bucket per key (endpoint + principal already
+// folded into the key by the caller).
+type rateLimiter struct {
+	rps   float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = 1
+	}
+	rl := &rateLimiter{
+		rps:     rps,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+	go rl.sweepIdleBuckets()
+	return rl
+}
+
+// sweepIdleBuckets periodically drops buckets that haven't been touched in
+// bucketIdleTTL, so keying by raw remote address doesn't grow the map
+// without bound.
+func (rl *rateLimiter) sweepIdleBuckets() {
+	ticker := time.NewTicker(bucketIdleTTL / 6)
+	defer ticker.Stop()
+	for range ticker.C {
+		cutoff := time.Now().Add(-bucketIdleTTL)
+		rl.mu.Lock()
+		for key, b := range rl.buckets {
+			if b.last.Before(cutoff) {
+				delete(rl.buckets, key)
+			}
+		}
+		rl.mu.Unlock()
+	}
+}
+
+func (rl *rateLimiter) allow(key string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: rl.burst - 1, last: now}
+		rl.buckets[key] = b
+		return true
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * rl.rps
+	if b.tokens > rl.burst {
+		b.tokens = rl.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// clientKey derives the rate-limit bucket key for a request: the
+// authenticated principal when there is one, otherwise the client's IP with
+// any ephemeral port stripped off (RemoteAddr is "ip:port", and a new
+// connection gets a new port, so keying on the raw value would give every
+// connection its own bucket).
+func clientKey(principal, remoteAddr string) string {
+	if principal != "" {
+		return principal
+	}
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os/exec"
+	"sync"
+	"syscall"
+
+	"github.com/creack/pty"
+	"github.com/gorilla/websocket"
+)
+
+// wsFrame is the JSON envelope written to the client for every chunk of
+// output and for the terminal "exit" frame.
+type wsFrame struct {
+	Stream   string `json:"stream"`
+	Data     string `json:"data,omitempty"`
+	Code     int    `json:"code,omitempty"`
+	TimedOut bool   `json:"timed_out,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+const wsReadChunkSize = 4096
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  wsReadChunkSize,
+	WriteBufferSize: wsReadChunkSize,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// serveWebSocket upgrades the request and streams the endpoint's command
+// output as framed messages, writing any incoming client text frames to the
+// process's stdin (or the PTY master, for PTY endpoints). It returns the
+// command's exit code and the total bytes streamed, for the caller's audit
+// log; exitCode is -1 if the upgrade or the command itself failed to start.
+func serveWebSocket(w http.ResponseWriter, r *http.Request, defaultTimeout int, ep Endpoint) (exitCode int, bytesOut int) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("%s: websocket upgrade failed: %v", ep.Path, err)
+		return -1, 0
+	}
+	defer conn.Close()
+
+	cmd, ctx, cancel := newCommandContext(r.Context(), defaultTimeout, ep)
+	defer cancel()
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	var writeMu sync.Mutex
+	writeFrame := func(f wsFrame) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteJSON(f)
+	}
+
+	if ep.PTY {
+		return runWebSocketPTY(ctx, conn, cmd, writeFrame)
+	}
+	return runWebSocketPipes(ctx, conn, cmd, writeFrame)
+}
+
+func runWebSocketPipes(ctx context.Context, conn *websocket.Conn, cmd *exec.Cmd, writeFrame func(wsFrame) error) (exitCode int, bytesOut int) {
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		_ = writeFrame(wsFrame{Stream: "exit", Code: -1, Error: err.Error()})
+		return -1, 0
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		_ = writeFrame(wsFrame{Stream: "exit", Code: -1, Error: err.Error()})
+		return -1, 0
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		_ = writeFrame(wsFrame{Stream: "exit", Code: -1, Error: err.Error()})
+		return -1, 0
+	}
+
+	if err := cmd.Start(); err != nil {
+		_ = writeFrame(wsFrame{Stream: "exit", Code: -1, Error: err.Error()})
+		return -1, 0
+	}
+
+	var stdoutCount, stderrCount int64
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go pumpStream(&wg, "stdout", stdout, writeFrame, &stdoutCount)
+	go pumpStream(&wg, "stderr", stderr, writeFrame, &stderrCount)
+	go pumpStdin(conn, stdin, true)
+
+	go func() {
+		<-ctx.Done()
+		killProcessGroup(cmd)
+	}()
+
+	wg.Wait()
+	err = cmd.Wait()
+
+	code, timedOut := exitStatus(ctx, err)
+	_ = writeFrame(wsFrame{Stream: "exit", Code: code, TimedOut: timedOut})
+	return code, int(stdoutCount + stderrCount)
+}
+
+func runWebSocketPTY(ctx context.Context, conn *websocket.Conn, cmd *exec.Cmd, writeFrame func(wsFrame) error) (exitCode int, bytesOut int) {
+	ptyFile, err := pty.Start(cmd)
+	if err != nil {
+		_ = writeFrame(wsFrame{Stream: "exit", Code: -1, Error: err.Error()})
+		return -1, 0
+	}
+	defer ptyFile.Close()
+
+	var stdoutCount int64
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go pumpStream(&wg, "stdout", ptyFile, writeFrame, &stdoutCount)
+	// The PTY master is a single fd shared by both directions, unlike the
+	// pipes path where stdin/stdout are distinct fds. Closing it here on a
+	// stdin-side disconnect would also cut off the stdout pump above while
+	// the process is still running, so the PTY path leaves the close to the
+	// deferred ptyFile.Close() once both the pump and the command are done.
+	go pumpStdin(conn, ptyFile, false)
+
+	go func() {
+		<-ctx.Done()
+		killProcessGroup(cmd)
+	}()
+
+	wg.Wait()
+	err = cmd.Wait()
+
+	code, timedOut := exitStatus(ctx, err)
+	_ = writeFrame(wsFrame{Stream: "exit", Code: code, TimedOut: timedOut})
+	return code, int(stdoutCount)
+}
+
+// pumpStream reads fixed-size chunks from r and forwards each as a framed
+// message on the given stream name until r is exhausted, accumulating the
+// number of bytes read into count.
+func pumpStream(wg *sync.WaitGroup, stream string, r io.Reader, writeFrame func(wsFrame) error, count *int64) {
+	defer wg.Done()
+	buf := make([]byte, wsReadChunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			*count += int64(n)
+			if werr := writeFrame(wsFrame{Stream: stream, Data: string(buf[:n])}); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// pumpStdin reads text frames from the client and writes them to stdin
+// until the connection closes. closeOnDone closes stdin once the read loop
+// ends; pass false when stdin shares an fd with a stdout pump that must keep
+// running after the client stops sending (the PTY path).
+func pumpStdin(conn *websocket.Conn, stdin io.WriteCloser, closeOnDone bool) {
+	if closeOnDone {
+		defer stdin.Close()
+	}
+	for {
+		msgType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if msgType != websocket.TextMessage && msgType != websocket.BinaryMessage {
+			continue
+		}
+		if _, err := stdin.Write(data); err != nil {
+			return
+		}
+	}
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+func exitStatus(ctx context.Context, err error) (code int, timedOut bool) {
+	timedOut = errors.Is(ctx.Err(), context.DeadlineExceeded)
+	if err == nil {
+		return 0, timedOut
+	}
+	if timedOut {
+		return -1, true
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), false
+	}
+	return -1, false
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Annotation is a GitHub-Actions-style `::notice|warning|error|debug ...::msg`
+// command surfaced as a structured result.
+type Annotation struct {
+	Level   string `json:"level"`
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Col     int    `json:"col,omitempty"`
+	Message string `json:"message"`
+}
+
+// Group is the content of a `::group::name` / `::endgroup::` block.
+type Group struct {
+	Name  string   `json:"name"`
+	Lines []string `json:"lines"`
+}
+
+var (
+	workflowCmdRe   = regexp.MustCompile(`^::([a-zA-Z][a-zA-Z-]*)(?:\s+([^:]*))?::(.*)$`)
+	workflowMultiRe = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)<<([A-Za-z0-9_-]+)$`)
+)
+
+// applyWorkflowCommands scans result.Stdout and result.Stderr for
+// GitHub-Actions-style workflow commands, populates Annotations, Groups, and
+// Summary, applies any `::add-mask::` values, and strips the command lines
+// themselves out of the visible output.
+func applyWorkflowCommands(result *CommandResult) {
+	var masks []string
+
+	stdout, masks := scanWorkflowCommands(result.Stdout, result, masks)
+	stderr, masks := scanWorkflowCommands(result.Stderr, result, masks)
+
+	for _, mask := range masks {
+		if mask == "" {
+			continue
+		}
+		stdout = strings.ReplaceAll(stdout, mask, "***")
+		stderr = strings.ReplaceAll(stderr, mask, "***")
+		result.Summary = strings.ReplaceAll(result.Summary, mask, "***")
+		for i := range result.Groups {
+			for j, line := range result.Groups[i].Lines {
+				result.Groups[i].Lines[j] = strings.ReplaceAll(line, mask, "***")
+			}
+		}
+		for i := range result.Annotations {
+			result.Annotations[i].Message = strings.ReplaceAll(result.Annotations[i].Message, mask, "***")
+			result.Annotations[i].File = strings.ReplaceAll(result.Annotations[i].File, mask, "***")
+		}
+	}
+
+	result.Stdout = stdout
+	result.Stderr = stderr
+}
+
+// scanWorkflowCommands processes one stream, appending to result.Annotations,
+// result.Groups, and result.Summary in place, and returns the stream with
+// command lines removed along with any masks it discovered.
+func scanWorkflowCommands(text string, result *CommandResult, masks []string) (string, []string) {
+	if text == "" {
+		return text, masks
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var kept []string
+	var currentGroup *Group
+
+	inMulti := false
+	var multiDelim string
+	var multiLines []string
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if inMulti {
+			if line == multiDelim {
+				appendSummary(result, strings.Join(multiLines, "\n"))
+				inMulti = false
+				multiLines = nil
+				continue
+			}
+			multiLines = append(multiLines, line)
+			continue
+		}
+
+		if m := workflowMultiRe.FindStringSubmatch(line); m != nil {
+			inMulti = true
+			multiDelim = m[2]
+			continue
+		}
+
+		if m := workflowCmdRe.FindStringSubmatch(line); m != nil {
+			name := strings.ToLower(m[1])
+			params := parseWorkflowParams(m[2])
+			message := m[3]
+
+			switch name {
+			case "notice", "warning", "error", "debug":
+				result.Annotations = append(result.Annotations, Annotation{
+					Level:   name,
+					File:    params["file"],
+					Line:    atoiOrZero(params["line"]),
+					Col:     atoiOrZero(params["col"]),
+					Message: message,
+				})
+			case "group":
+				result.Groups = append(result.Groups, Group{Name: message})
+				currentGroup = &result.Groups[len(result.Groups)-1]
+				continue
+			case "endgroup":
+				currentGroup = nil
+				continue
+			case "add-mask":
+				masks = append(masks, message)
+			}
+
+			if currentGroup != nil {
+				currentGroup.Lines = append(currentGroup.Lines, line)
+			}
+			continue
+		}
+
+		if currentGroup != nil {
+			currentGroup.Lines = append(currentGroup.Lines, line)
+		}
+		kept = append(kept, line)
+	}
+
+	return strings.Join(kept, "\n"), masks
+}
+
+func appendSummary(result *CommandResult, content string) {
+	if result.Summary != "" {
+		result.Summary += "\n"
+	}
+	result.Summary += content
+}
+
+func parseWorkflowParams(raw string) map[string]string {
+	params := map[string]string{}
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		params[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return params
+}
+
+func atoiOrZero(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// computeStatus derives the HTTP status for a CommandResult, promoting any
+// `::error::` annotation to a 500 when the endpoint parses workflow commands
+// and promotion hasn't been disabled.
+func computeStatus(ep Endpoint, result CommandResult) int {
+	status := http.StatusOK
+	if result.Error != "" {
+		status = http.StatusInternalServerError
+	}
+	if result.TimedOut {
+		status = http.StatusGatewayTimeout
+	}
+
+	if ep.ParseWorkflowCommands && promoteErrorStatus(ep) && hasErrorAnnotation(result.Annotations) {
+		status = http.StatusInternalServerError
+	}
+
+	return status
+}
+
+func promoteErrorStatus(ep Endpoint) bool {
+	if ep.PromoteErrorStatus == nil {
+		return true
+	}
+	return *ep.PromoteErrorStatus
+}
+
+func hasErrorAnnotation(annotations []Annotation) bool {
+	for _, a := range annotations {
+		if a.Level == "error" {
+			return true
+		}
+	}
+	return false
+}